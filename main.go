@@ -3,14 +3,19 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/spf13/pflag"
+
+	"github.com/haccht/timeconv/strftime"
 )
 
 const layoutExamples = `  ANSIC       "Mon Jan _2 15:04:05 2006"
@@ -35,7 +40,39 @@ const layoutExamples = `  ANSIC       "Mon Jan _2 15:04:05 2006"
   Unix-Milli  "1136239445000"
   Unix-Micro  "1136239445000000"
 
-  Arbitrary formats are also supported. See https://pkg.go.dev/time as a reference.`
+  Arbitrary formats are also supported. See https://pkg.go.dev/time as a reference.
+
+  Strftime-style layouts are also accepted, either prefixed with "strftime:"
+  or auto-detected from a "%" conversion, e.g.:
+
+  strftime:%Y-%m-%dT%H:%M:%S%z   "2023-10-26T12:57:09+0900"
+  %b %e %H:%M:%S                 "Oct 26 12:57:09"
+  %s                              1698292629
+
+  A --out value containing "{{" is rendered as a text/template, with .Time
+  (the parsed, offset time) and .Original (the raw input token) in scope and
+  formatTime, parseTime, toEpoch, inLocation and addDuration registered as
+  helpers, e.g.:
+
+  --out '{"ts":"{{ formatTime "rfc3339" .Time }}","epoch":{{ toEpoch "unix" .Time }}}'
+
+  A zoneless input layout (DateTime, DateOnly, TimeOnly, Kitchen, the Stamp
+  family, or a strftime spec without %z/%Z) parses as UTC by default. Passing
+  --assume-location instead interprets its wall-clock fields as a time in
+  that location:
+
+  --assume-location Asia/Tokyo reads "2023-10-26 12:57:09" as noon in Tokyo,
+  not UTC.
+
+  --dst-gap controls what happens when --assume-location's wall-clock time
+  falls in a spring-forward gap (a local time that never occurred):
+  "error" (default) rejects it; "forward" accepts the shift Go applies by
+  default, landing on the first instant after the gap.
+
+  --dst-fold controls which instant is chosen when the wall-clock time is
+  ambiguous after a fall-back (it occurred twice, once before and once after
+  the clocks were set back): "earlier" (default) picks the first occurrence;
+  "later" picks the second.`
 
 var knownLayouts = map[string]string{
 	"ansic":       time.ANSIC,
@@ -64,29 +101,116 @@ var epochLayouts = map[string]int64{
 	"unix-micro": 1,
 }
 
+// extractPatterns gives a regular expression fragment that matches text
+// shaped like each known layout, for locating a timestamp embedded in a
+// larger line (see --extract). Entries are ordered most-specific first so
+// that auto-detection tries the tightest match before a looser one that
+// could also match it (e.g. fractional-second stamps before plain ones).
+var extractPatterns = []struct {
+	format  string
+	pattern string
+}{
+	{"rfc3339nano", `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d+(?:[+-]\d{2}:\d{2}|Z)`},
+	{"rfc3339", `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:[+-]\d{2}:\d{2}|Z)`},
+	{"rfc1123z", `[A-Za-z]{3}, \d{2} [A-Za-z]{3} \d{4} \d{2}:\d{2}:\d{2} [+-]\d{4}`},
+	{"rfc1123", `[A-Za-z]{3}, \d{2} [A-Za-z]{3} \d{4} \d{2}:\d{2}:\d{2} [A-Za-z]{3,4}`},
+	{"rfc850", `[A-Za-z]+, \d{2}-[A-Za-z]{3}-\d{2} \d{2}:\d{2}:\d{2} [A-Za-z]{3,4}`},
+	{"rfc822z", `\d{2} [A-Za-z]{3} \d{2} \d{2}:\d{2} [+-]\d{4}`},
+	{"rfc822", `\d{2} [A-Za-z]{3} \d{2} \d{2}:\d{2} [A-Za-z]{3,4}`},
+	{"rubydate", `[A-Za-z]{3} [A-Za-z]{3} \d{2} \d{2}:\d{2}:\d{2} [+-]\d{4} \d{4}`},
+	{"unixdate", `[A-Za-z]{3} [A-Za-z]{3} [ \d]\d \d{2}:\d{2}:\d{2} [A-Za-z]{3,4} \d{4}`},
+	{"ansic", `[A-Za-z]{3} [A-Za-z]{3} [ \d]\d \d{2}:\d{2}:\d{2} \d{4}`},
+	{"stampnano", `[A-Za-z]{3} [ \d]\d \d{2}:\d{2}:\d{2}\.\d{9}`},
+	{"stampmicro", `[A-Za-z]{3} [ \d]\d \d{2}:\d{2}:\d{2}\.\d{6}`},
+	{"stampmilli", `[A-Za-z]{3} [ \d]\d \d{2}:\d{2}:\d{2}\.\d{3}`},
+	{"stamp", `[A-Za-z]{3} [ \d]\d \d{2}:\d{2}:\d{2}`},
+	{"kitchen", `\d{1,2}:\d{2}(?:AM|PM)`},
+	{"datetime", `\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}`},
+	{"dateonly", `\d{4}-\d{2}-\d{2}`},
+	{"timeonly", `\d{2}:\d{2}:\d{2}`},
+	{"unix", `\d{9,19}(?:\.\d+)?`},
+}
+
+// autoDetectPattern matches anything shaped like one of extractPatterns, for
+// --extract without an explicit --in.
+var autoDetectPattern = regexp.MustCompile(joinExtractPatterns())
+
+func joinExtractPatterns() string {
+	parts := make([]string, len(extractPatterns))
+	for i, p := range extractPatterns {
+		parts[i] = "(?:" + p.pattern + ")"
+	}
+	return strings.Join(parts, "|")
+}
+
+// extractPattern returns the regular expression used to locate a timestamp
+// for the given --in format: the auto-detect alternation when format is
+// empty, a pattern derived from a strftime spec, or the hand-written pattern
+// for one of the built-in named layouts.
+func extractPattern(format string) (*regexp.Regexp, error) {
+	if format == "" {
+		return autoDetectPattern, nil
+	}
+
+	if spec, ok := strftimeSpec(format); ok {
+		pattern, epoch, err := strftime.ToPattern(spec)
+		if err != nil {
+			return nil, err
+		}
+		if epoch {
+			format = "unix"
+		} else {
+			return regexp.Compile(pattern)
+		}
+	}
+
+	for _, p := range extractPatterns {
+		if p.format == format {
+			return regexp.Compile(p.pattern)
+		}
+	}
+	return nil, fmt.Errorf("no extraction pattern for format: %s", format)
+}
+
 type guessRule struct {
 	re      *regexp.Regexp
 	layouts []string
 }
 
 var guessRules = []guessRule{
-	{regexp.MustCompile(`^\d{10,19}(?:\.\d+)?$`), []string{"unix", "unix-milli", "unix-micro"}},
+	{regexp.MustCompile(`^\d{9,19}(?:\.\d+)?$`), []string{"unix", "unix-milli", "unix-micro"}},
 	{regexp.MustCompile(`^\d{4}`), []string{"rfc3339", "rfc3339nano", "datetime", "dateonly"}},
 	{regexp.MustCompile(`[A-Za-z]{3,4}|[+-]\d{4}`), []string{"unixdate", "rubydate", "rfc822", "rfc822z", "rfc850", "rfc1123", "rfc1123z", "rfc3339", "rfc3339nano"}},
 	{regexp.MustCompile(`^[A-Za-z]{3},?`), []string{"ansic", "unixdate", "rubydate", "rfc822", "rfc822z", "rfc850", "rfc1123", "rfc1123z", "stamp", "stampmilli", "stampmicro", "stampnano"}},
 	{regexp.MustCompile(`\d{2}:\d{2}:\d{2}`), []string{"datetime", "timeonly", "ansic", "unixdate", "rubydate", "rfc850", "rfc1123", "rfc1123z"}},
 	{regexp.MustCompile(`\d{1,2}:\d{2}(AM|PM)`), []string{"kitchen"}},
+	// Syslog lines pad a single-digit day with a space rather than a zero
+	// (e.g. "Jul  6 15:04:05"); time.Stamp expects the zero-padded form, so
+	// fall back to the equivalent strftime layout.
+	{regexp.MustCompile(`^[A-Za-z]{3}\s+\d{1,2}\s+\d{2}:\d{2}:\d{2}`), []string{"strftime:%b %e %H:%M:%S"}},
+	// A slash-separated date is genuinely ambiguous between month-first
+	// (US-style) and day-first (most everywhere else) order; both parse, so
+	// listing month-first first makes it the tie-broken default while
+	// --explain still surfaces the day-first reading as a runner-up.
+	{regexp.MustCompile(`^\d{1,2}/\d{1,2}/\d{4}$`), []string{"strftime:%m/%d/%Y", "strftime:%d/%m/%Y"}},
 }
 
 type options struct {
-	in     string
-	out    string
-	now    bool
-	add    time.Duration
-	sub    time.Duration
-	loc    locationValue
-	re     regexpValue
-	inputs []string
+	in         string
+	out        string
+	now        bool
+	add        time.Duration
+	sub        time.Duration
+	loc        locationValue
+	assumeLoc  optionalLocationValue
+	dstGap     string
+	dstFold    string
+	re         regexpValue
+	extract    bool
+	extractAll bool
+	explain    bool
+	bufferSize int
+	inputs     []string
 }
 
 func parseFlags() *options {
@@ -99,7 +223,14 @@ func parseFlags() *options {
 	pflag.DurationVarP(&opts.add, "add", "a", time.Duration(0), "Append time duration (ex. 5m, 1.5h, 1h30m)")
 	pflag.DurationVarP(&opts.sub, "sub", "s", time.Duration(0), "Substruct time duration (ex. 5m, 1.5h, 1h30m)")
 	pflag.VarP(&opts.loc, "location", "l", "Timezone location (e.g., UTC, Asia/Tokyo)")
-	pflag.VarP(&opts.re, "grep", "g", "Replace strings that match the regular expression")
+	pflag.Var(&opts.assumeLoc, "assume-location", "Timezone to interpret a zoneless input layout in (default: UTC, as before)")
+	pflag.StringVar(&opts.dstGap, "dst-gap", "error", "How to handle a local time skipped by a DST spring-forward: error or forward")
+	pflag.StringVar(&opts.dstFold, "dst-fold", "earlier", "Which instant to pick for a local time made ambiguous by a DST fall-back: earlier or later")
+	pflag.VarP(&opts.re, "grep", "g", "Replace strings that match the regular expression (or its named capture group)")
+	pflag.BoolVar(&opts.extract, "extract", false, "Rewrite the first timestamp found in each line, leaving the rest unchanged")
+	pflag.BoolVar(&opts.extractAll, "extract-all", false, "Rewrite every timestamp found in each line, leaving the rest unchanged")
+	pflag.IntVar(&opts.bufferSize, "buffer-size", bufio.MaxScanTokenSize, "Maximum size in bytes of a single input line")
+	pflag.BoolVar(&opts.explain, "explain", false, "Print the auto-detected layout and runner-up candidates to stderr")
 	pflag.CommandLine.SortFlags = false
 	pflag.Usage = func() {
 		fmt.Fprintln(os.Stderr, "Usage:")
@@ -114,16 +245,55 @@ func parseFlags() *options {
 	pflag.Parse()
 
 	opts.inputs = pflag.Args()
-	opts.in = strings.ToLower(opts.in)
-	opts.out = strings.ToLower(opts.out)
+	opts.in = normalizeFormat(opts.in)
+	opts.out = normalizeFormat(opts.out)
 	return &opts
 }
 
+// normalizeFormat lowercases format if doing so names one of the built-in
+// layouts, so flag values like "RFC3339" or "UnixDate" still match. Anything
+// else, such as a strftime layout or a custom Go reference layout, is
+// case sensitive and is returned unchanged.
+func normalizeFormat(format string) string {
+	lower := strings.ToLower(format)
+	if _, ok := knownLayouts[lower]; ok {
+		return lower
+	}
+	if _, ok := epochLayouts[lower]; ok {
+		return lower
+	}
+	return format
+}
+
+// strftimeSpec reports whether format is a strftime-style layout, either
+// explicitly marked with a "strftime:" prefix or auto-detected from the
+// presence of a "%" conversion, and returns the bare strftime string.
+func strftimeSpec(format string) (string, bool) {
+	if spec, ok := strings.CutPrefix(format, "strftime:"); ok {
+		return spec, true
+	}
+	if strings.ContainsRune(format, '%') {
+		return format, true
+	}
+	return format, false
+}
+
 func stringToTime(s, format string) (time.Time, error) {
 	if format == "" {
 		return guessTime(s)
 	}
 
+	if spec, ok := strftimeSpec(format); ok {
+		layout, epoch, err := strftime.ToLayout(spec)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if epoch {
+			return stringToTime(s, "unix")
+		}
+		return time.Parse(layout, s)
+	}
+
 	if scale, ok := epochLayouts[format]; ok {
 		v, err := strconv.ParseFloat(s, 64)
 		if err != nil {
@@ -139,6 +309,15 @@ func stringToTime(s, format string) (time.Time, error) {
 }
 
 func timeToString(t time.Time, format string) string {
+	if spec, ok := strftimeSpec(format); ok {
+		if layout, epoch, err := strftime.ToLayout(spec); err == nil {
+			if epoch {
+				return timeToString(t, "unix")
+			}
+			return t.Format(layout)
+		}
+	}
+
 	if scale, ok := epochLayouts[format]; ok {
 		v := float64(t.UnixMicro())
 		return strconv.FormatFloat(v/float64(scale), 'f', -1, 64)
@@ -150,25 +329,513 @@ func timeToString(t time.Time, format string) string {
 	return t.Format(format)
 }
 
-func guessTime(s string) (time.Time, error) {
+// zoneTokens are the Go reference-layout fragments that carry explicit
+// timezone information, as opposed to one time.Parse silently resolves to
+// UTC.
+var zoneTokens = []string{"-0700", "-07:00", "Z07:00", "MST"}
+
+// formatHasZone reports whether format, as applied to s, carries explicit
+// timezone information. It drives --assume-location: a zoneless layout
+// (datetime, dateonly, timeonly, kitchen, the Stamp family, or a strftime
+// spec without %z/%Z) is a candidate for reinterpretation in the assumed
+// location instead of UTC.
+func formatHasZone(format, s string) bool {
+	if format == "" {
+		candidates := guessCandidates(s)
+		if len(candidates) == 0 {
+			return true
+		}
+		return candidates[0].hasZone
+	}
+
+	if spec, ok := strftimeSpec(format); ok {
+		layout, epoch, err := strftime.ToLayout(spec)
+		if err != nil {
+			return true
+		}
+		if epoch {
+			return true
+		}
+		for _, tok := range zoneTokens {
+			if strings.Contains(layout, tok) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if _, ok := epochLayouts[format]; ok {
+		return true
+	}
+
+	return layoutHasZone[format]
+}
+
+// resolveAmbiguousLocal interprets the wall-clock fields y/mo/d/h/mi/sec/ns
+// (as parsed from input with no zone of its own) as a time in loc, handling
+// the two DST edge cases explicitly:
+//
+//   - a spring-forward gap, where the wall-clock time doesn't exist: dstGap
+//     selects whether this is an "error" or resolved by skipping "forward"
+//     past the gap, to the first instant the given wall-clock hour occurs.
+//   - a fall-back fold, where the wall-clock time is valid under two
+//     different UTC offsets: dstFold picks the "earlier" or "later" instant.
+func resolveAmbiguousLocal(y int, mo time.Month, d, h, mi, sec, ns int, loc *time.Location, dstGap, dstFold string) (time.Time, error) {
+	naive := time.Date(y, mo, d, h, mi, sec, ns, loc)
+
+	gy, gmo, gd := naive.Date()
+	gh, gmi, gsec := naive.Clock()
+	if gy != y || gmo != mo || gd != d || gh != h || gmi != mi || gsec != sec {
+		if dstGap == "error" {
+			return time.Time{}, fmt.Errorf("%04d-%02d-%02d %02d:%02d:%02d does not exist in %s (DST gap)", y, int(mo), d, h, mi, sec, loc)
+		}
+
+		// naive landed on the pre-transition side of the gap (Go resolves a
+		// nonexistent local time using the offset in effect just before it).
+		// Add the size of the gap to land on the first instant after it,
+		// matching the wall-clock hour the caller actually asked for.
+		_, preOffset := naive.Add(-3 * time.Hour).Zone()
+		_, postOffset := naive.Add(3 * time.Hour).Zone()
+		return naive.Add(time.Duration(postOffset-preOffset) * time.Second), nil
+	}
+
+	_, naiveOffset := naive.Zone()
+	for _, probe := range []time.Duration{-3 * time.Hour, 3 * time.Hour} {
+		_, probeOffset := naive.Add(probe).Zone()
+		if probeOffset == naiveOffset {
+			continue
+		}
+
+		alt := time.Date(y, mo, d, h, mi, sec, ns, time.FixedZone("", probeOffset))
+		if ay, amo, ad := alt.Date(); ay != y || amo != mo || ad != d {
+			continue
+		}
+		if ah, ami, asec := alt.Clock(); ah != h || ami != mi || asec != sec {
+			continue
+		}
+
+		earlier, later := naive, alt
+		if alt.Before(naive) {
+			earlier, later = alt, naive
+		}
+		if dstFold == "later" {
+			return later, nil
+		}
+		return earlier, nil
+	}
+
+	return naive, nil
+}
+
+// resolveAssumedLocation reinterprets t's wall-clock fields (as produced by
+// parsing a zoneless layout, which time.Parse defaults to UTC) as a time in
+// loc instead, applying the dstGap/dstFold policies.
+func resolveAssumedLocation(t time.Time, loc *time.Location, dstGap, dstFold string) (time.Time, error) {
+	y, mo, d := t.Date()
+	h, mi, sec := t.Clock()
+	return resolveAmbiguousLocal(y, mo, d, h, mi, sec, t.Nanosecond(), loc, dstGap, dstFold)
+}
+
+// templateTime wraps time.Time so templateData.Time can expose an In method
+// that, unlike time.Time.In, takes a location name directly (e.g.
+// `{{ .Time.In "UTC" | formatTime "kitchen" }}`) instead of a *time.Location.
+type templateTime struct {
+	time.Time
+}
+
+func (tt templateTime) In(name string) (templateTime, error) {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return templateTime{}, err
+	}
+	return templateTime{tt.Time.In(loc)}, nil
+}
+
+// templateData is the value passed to a --out template.
+type templateData struct {
+	Time     templateTime // the (already shifted/offset) parsed time
+	Original string       // the raw input token, before parsing
+}
+
+// asTime accepts either a time.Time or a templateTime, so template helpers
+// can take the result of a plain ".Time" field access or of a chained
+// helper/method call (formatTime, inLocation, addDuration, .Time.In, ...)
+// interchangeably.
+func asTime(v any) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case templateTime:
+		return t.Time, nil
+	default:
+		return time.Time{}, fmt.Errorf("template: expected a time value, got %T", v)
+	}
+}
+
+var templateFuncMap = template.FuncMap{
+	"formatTime": func(format string, v any) (string, error) {
+		t, err := asTime(v)
+		if err != nil {
+			return "", err
+		}
+		return timeToString(t, normalizeFormat(format)), nil
+	},
+	"parseTime": func(format, s string) (templateTime, error) {
+		t, err := stringToTime(s, normalizeFormat(format))
+		if err != nil {
+			return templateTime{}, err
+		}
+		return templateTime{t}, nil
+	},
+	"toEpoch": func(scale string, v any) (int64, error) {
+		t, err := asTime(v)
+		if err != nil {
+			return 0, err
+		}
+		switch strings.ToLower(scale) {
+		case "unix":
+			return t.Unix(), nil
+		case "milli":
+			return t.UnixMilli(), nil
+		case "micro":
+			return t.UnixMicro(), nil
+		case "nano":
+			return t.UnixNano(), nil
+		default:
+			return 0, fmt.Errorf("toEpoch: unknown scale %q", scale)
+		}
+	},
+	"inLocation": func(name string, v any) (templateTime, error) {
+		t, err := asTime(v)
+		if err != nil {
+			return templateTime{}, err
+		}
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return templateTime{}, err
+		}
+		return templateTime{t.In(loc)}, nil
+	},
+	"addDuration": func(d string, v any) (templateTime, error) {
+		t, err := asTime(v)
+		if err != nil {
+			return templateTime{}, err
+		}
+		dur, err := time.ParseDuration(d)
+		if err != nil {
+			return templateTime{}, err
+		}
+		return templateTime{t.Add(dur)}, nil
+	},
+}
+
+// isOutputTemplate reports whether format should be treated as a
+// text/template string rather than a layout name or reference layout.
+func isOutputTemplate(format string) bool {
+	return strings.Contains(format, "{{")
+}
+
+// renderOutputTemplate executes format, a --out value containing "{{", as a
+// text/template against data.
+func renderOutputTemplate(format string, data templateData) (string, error) {
+	tmpl, err := template.New("out").Funcs(templateFuncMap).Parse(format)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse output template: %w", err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render output template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// layoutChunks gives a rough count of the distinct date/time fields each
+// layout carries, used to prefer a more specific guess (e.g. RFC3339Nano,
+// with a fractional second, over RFC3339) when several layouts parse.
+var layoutChunks = map[string]int{
+	"ansic":       5,
+	"unixdate":    6,
+	"rubydate":    6,
+	"rfc822":      4,
+	"rfc822z":     4,
+	"rfc850":      5,
+	"rfc1123":     5,
+	"rfc1123z":    5,
+	"rfc3339":     4,
+	"rfc3339nano": 5,
+	"kitchen":     2,
+	"stamp":       4,
+	"stampmilli":  5,
+	"stampmicro":  5,
+	"stampnano":   5,
+	"datetime":    5,
+	"dateonly":    3,
+	"timeonly":    3,
+	"unix":        1,
+	"unix-milli":  1,
+	"unix-micro":  1,
+
+	"strftime:%m/%d/%Y": 3,
+	"strftime:%d/%m/%Y": 3,
+}
+
+// epochWidth gives the digit count a Unix-epoch number is expected to have
+// at each scale in the current era, used to tell unix/unix-milli/unix-micro
+// apart: all three parse any all-digit string, since ParseFloat doesn't
+// care about magnitude, but only one width is plausible for a given string.
+var epochWidth = map[string]int{
+	"unix":       10,
+	"unix-milli": 13,
+	"unix-micro": 16,
+}
+
+// layoutHasZone marks layouts that carry explicit timezone information, as
+// opposed to ones time.Parse silently resolves to UTC.
+var layoutHasZone = map[string]bool{
+	"unixdate":    true,
+	"rubydate":    true,
+	"rfc822":      true,
+	"rfc822z":     true,
+	"rfc850":      true,
+	"rfc1123":     true,
+	"rfc1123z":    true,
+	"rfc3339":     true,
+	"rfc3339nano": true,
+	"unix":        true,
+	"unix-milli":  true,
+	"unix-micro":  true,
+}
+
+// guessCandidate is one layout that successfully parsed s while guessing,
+// along with the evidence used to rank it against the others.
+type guessCandidate struct {
+	layout string
+	t      time.Time
+
+	consumed int  // bytes of s the matching guessRule recognized as a timestamp
+	chunks   int  // how many date/time fields the layout carries
+	tight    bool // the guessRule matched the whole of s, not just part of it
+	hasZone  bool // the layout carries explicit timezone information
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// betterCandidate reports whether a should be preferred over b: the rule
+// match that consumed more of the input wins first, then the more specific
+// layout, then an anchored full-string match, then explicit timezone info.
+func betterCandidate(a, b guessCandidate) bool {
+	if a.consumed != b.consumed {
+		return a.consumed > b.consumed
+	}
+	if a.chunks != b.chunks {
+		return a.chunks > b.chunks
+	}
+	if a.tight != b.tight {
+		return a.tight
+	}
+	return a.hasZone && !b.hasZone
+}
+
+// guessCandidates tries every layout named by a matching guessRule and
+// returns the ones that parsed s successfully, best candidate first.
+func guessCandidates(s string) []guessCandidate {
+	var candidates []guessCandidate
+	seen := make(map[string]int, len(guessRules))
+
 	for _, rule := range guessRules {
-		if rule.re.MatchString(s) {
-			for _, l := range rule.layouts {
-				if t, err := stringToTime(s, l); err == nil {
-					return t, nil
+		loc := rule.re.FindStringIndex(s)
+		if loc == nil {
+			continue
+		}
+
+		for _, l := range rule.layouts {
+			t, err := stringToTime(s, l)
+			if err != nil {
+				continue
+			}
+
+			chunks := layoutChunks[l]
+			if w, ok := epochWidth[l]; ok {
+				// Score how closely s's digit count matches this scale's
+				// expected width instead of the (otherwise tied) chunk count.
+				chunks = 100 - absInt(len(s)-w)
+			}
+
+			c := guessCandidate{
+				layout:   l,
+				t:        t,
+				consumed: loc[1] - loc[0],
+				chunks:   chunks,
+				tight:    loc[0] == 0 && loc[1] == len(s),
+				hasZone:  layoutHasZone[l],
+			}
+
+			if i, ok := seen[l]; ok {
+				if betterCandidate(c, candidates[i]) {
+					candidates[i] = c
 				}
+				continue
 			}
+			seen[l] = len(candidates)
+			candidates = append(candidates, c)
 		}
 	}
-	return time.Time{}, fmt.Errorf("Unknown format: %s", s)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return betterCandidate(candidates[i], candidates[j])
+	})
+	return candidates
 }
 
-func genScanner(args []string) *bufio.Scanner {
+func guessTime(s string) (time.Time, error) {
+	candidates := guessCandidates(s)
+	if len(candidates) == 0 {
+		return time.Time{}, fmt.Errorf("Unknown format: %s", s)
+	}
+	return candidates[0].t, nil
+}
+
+// explainGuess writes the layout guessTime chose for s, its score, and the
+// runner-up candidates to w, for debugging an ambiguous auto-detection
+// (e.g. "01/02/2006" vs "02/01/2006").
+func explainGuess(w io.Writer, s string) {
+	candidates := guessCandidates(s)
+	if len(candidates) == 0 {
+		fmt.Fprintf(w, "explain: no layout matched %q\n", s)
+		return
+	}
+
+	describe := func(c guessCandidate) string {
+		return fmt.Sprintf("%-12s consumed=%d chunks=%d tight=%v hasZone=%v", c.layout, c.consumed, c.chunks, c.tight, c.hasZone)
+	}
+
+	fmt.Fprintf(w, "explain: %q\n", s)
+	fmt.Fprintf(w, "  chosen:    %s\n", describe(candidates[0]))
+	for _, c := range candidates[1:] {
+		fmt.Fprintf(w, "  runner-up: %s\n", describe(c))
+	}
+}
+
+func genScanner(args []string, bufferSize int) *bufio.Scanner {
+	var scanner *bufio.Scanner
 	if len(args) > 0 {
 		reader := strings.NewReader(strings.Join(args, "\n"))
-		return bufio.NewScanner(reader)
+		scanner = bufio.NewScanner(reader)
+	} else {
+		scanner = bufio.NewScanner(os.Stdin)
+	}
+
+	if bufferSize > 0 {
+		scanner.Buffer(make([]byte, 0, 4096), bufferSize)
+	}
+	return scanner
+}
+
+// processTimeString runs the full input-to-output pipeline for a single
+// token: parse it per opts.in, apply opts.loc/opts.add/opts.sub, then
+// render it per opts.out.
+func processTimeString(s string, opts *options) (string, error) {
+	t, err := stringToTime(s, opts.in)
+	if err != nil {
+		return "", err
+	}
+
+	if opts.assumeLoc.set && !formatHasZone(opts.in, s) {
+		t, err = resolveAssumedLocation(t, opts.assumeLoc.Location, opts.dstGap, opts.dstFold)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	t = modifyTime(t, opts.loc, opts.add, opts.sub)
+	if isOutputTemplate(opts.out) {
+		return renderOutputTemplate(opts.out, templateData{Time: templateTime{t}, Original: s})
+	}
+	return timeToString(t, opts.out), nil
+}
+
+// extractTimeString rewrites the first (or, if all is true, every)
+// substring of line that looks like a timestamp in opts.in's format,
+// leaving the rest of the line untouched. A substring that matches the
+// pattern but fails to parse is left as-is.
+func extractTimeString(line string, opts *options, all bool) (string, error) {
+	pattern, err := extractPattern(opts.in)
+	if err != nil {
+		return "", err
+	}
+
+	matches := pattern.FindAllStringIndex(line, -1)
+	if len(matches) == 0 {
+		return line, nil
 	}
-	return bufio.NewScanner(os.Stdin)
+	if !all {
+		matches = matches[:1]
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		out, err := processTimeString(line[start:end], opts)
+		if err != nil {
+			continue
+		}
+
+		b.WriteString(line[last:start])
+		b.WriteString(out)
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String(), nil
+}
+
+// replaceWithRegexp rewrites every match of re in line. If re declares a
+// named capture group, only that group is converted and the rest of the
+// match (e.g. surrounding brackets) is left in place; otherwise the whole
+// match is converted, as before.
+func replaceWithRegexp(line string, re *regexp.Regexp, opts *options) string {
+	group := -1
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			group = i
+			break
+		}
+	}
+	if group == -1 {
+		return re.ReplaceAllStringFunc(line, func(s string) string {
+			out, err := processTimeString(s, opts)
+			if err != nil {
+				return s
+			}
+			return out
+		})
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(line, -1) {
+		start, end := loc[2*group], loc[2*group+1]
+		if start < 0 {
+			continue
+		}
+
+		out, err := processTimeString(line[start:end], opts)
+		if err != nil {
+			continue
+		}
+
+		b.WriteString(line[last:start])
+		b.WriteString(out)
+		last = end
+	}
+	b.WriteString(line[last:])
+	return b.String()
 }
 
 func modifyTime(t time.Time, loc locationValue, add, sub time.Duration) time.Time {
@@ -199,6 +866,36 @@ func (lv *locationValue) Type() string {
 	return "location"
 }
 
+// optionalLocationValue is like locationValue, but tracks whether the flag
+// was actually given, so --assume-location can default to "unset" (i.e.
+// the previous behavior of a zoneless layout parsing as UTC) rather than
+// to a real zone.
+type optionalLocationValue struct {
+	*time.Location
+	set bool
+}
+
+func (lv *optionalLocationValue) String() string {
+	if !lv.set || lv.Location == nil {
+		return ""
+	}
+	return lv.Location.String()
+}
+
+func (lv *optionalLocationValue) Set(value string) error {
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return fmt.Errorf("invalid location %q: %w", value, err)
+	}
+	lv.Location = loc
+	lv.set = true
+	return nil
+}
+
+func (lv *optionalLocationValue) Type() string {
+	return "location"
+}
+
 type regexpValue struct {
 	*regexp.Regexp
 }
@@ -212,12 +909,15 @@ func (rv *regexpValue) String() string {
 
 func (rv *regexpValue) Set(s string) error {
 	if s == "" {
-		re, err := regexp.Compile(s)
-		if err != nil {
-			return err
-		}
-		rv.Regexp = re
+		rv.Regexp = nil
+		return nil
+	}
+
+	re, err := regexp.Compile(s)
+	if err != nil {
+		return err
 	}
+	rv.Regexp = re
 	return nil
 }
 
@@ -228,42 +928,61 @@ func (rv *regexpValue) Type() string {
 func run() error {
 	opts := parseFlags()
 
+	if opts.dstGap != "error" && opts.dstGap != "forward" {
+		return fmt.Errorf("invalid --dst-gap %q: must be \"error\" or \"forward\"", opts.dstGap)
+	}
+	if opts.dstFold != "earlier" && opts.dstFold != "later" {
+		return fmt.Errorf("invalid --dst-fold %q: must be \"earlier\" or \"later\"", opts.dstFold)
+	}
+
+	out := bufio.NewWriterSize(os.Stdout, opts.bufferSize)
+	defer out.Flush()
+
 	if opts.now {
 		t := time.Now()
 		t = modifyTime(t, opts.loc, opts.add, opts.sub)
-		fmt.Println(timeToString(t, opts.out))
-	} else {
-		scanner := genScanner(opts.inputs)
-		for scanner.Scan() {
-			line := scanner.Text()
-			if opts.re.Regexp == nil {
-				t, err := stringToTime(strings.TrimSpace(line), opts.in)
-				if err != nil {
-					return err
-				}
 
-				t = modifyTime(t, opts.loc, opts.add, opts.sub)
-				fmt.Println(timeToString(t, opts.out))
-			} else {
-				replaced := opts.re.ReplaceAllStringFunc(line, func(s string) string {
-					t, err := stringToTime(s, opts.in)
-					if err != nil {
-						return s
-					}
-
-					t = modifyTime(t, opts.loc, opts.add, opts.sub)
-					return timeToString(t, opts.out)
-				})
-				fmt.Println(replaced)
+		if isOutputTemplate(opts.out) {
+			rendered, err := renderOutputTemplate(opts.out, templateData{Time: templateTime{t}})
+			if err != nil {
+				return err
 			}
+			fmt.Fprintln(out, rendered)
+			return nil
 		}
 
-		if err := scanner.Err(); err != nil {
-			return scanner.Err()
+		fmt.Fprintln(out, timeToString(t, opts.out))
+		return nil
+	}
+
+	scanner := genScanner(opts.inputs, opts.bufferSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case opts.extract || opts.extractAll:
+			extracted, err := extractTimeString(line, opts, opts.extractAll)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, extracted)
+		case opts.re.Regexp != nil:
+			fmt.Fprintln(out, replaceWithRegexp(line, opts.re.Regexp, opts))
+		default:
+			token := strings.TrimSpace(line)
+			if opts.explain && opts.in == "" {
+				explainGuess(os.Stderr, token)
+			}
+
+			result, err := processTimeString(token, opts)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(out, result)
 		}
 	}
 
-	return nil
+	return scanner.Err()
 }
 
 func main() {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"regexp"
 	"testing"
 	"time"
 )
@@ -89,6 +90,162 @@ func Test_timeToString(t *testing.T) {
 	}
 }
 
+func Test_processTimeString_template(t *testing.T) {
+	opts := &options{
+		in:  "unix",
+		out: `{"ts":"{{ formatTime "rfc3339" .Time }}","epoch":{{ .Time | toEpoch "unix" }},"orig":"{{ .Original }}"}`,
+		loc: locationValue{Location: time.UTC},
+	}
+
+	got, err := processTimeString("1698292629", opts)
+	if err != nil {
+		t.Fatalf("processTimeString failed: %v", err)
+	}
+
+	want := `{"ts":"2023-10-26T03:57:09Z","epoch":1698292629,"orig":"1698292629"}`
+	if got != want {
+		t.Errorf("processTimeString() = %q, want %q", got, want)
+	}
+}
+
+func Test_processTimeString_templateInLocation(t *testing.T) {
+	opts := &options{
+		in:  "unix",
+		out: `{{ .Time.In "Asia/Tokyo" | formatTime "kitchen" }}`,
+		loc: locationValue{Location: time.UTC},
+	}
+
+	got, err := processTimeString("1698292629", opts)
+	if err != nil {
+		t.Fatalf("processTimeString failed: %v", err)
+	}
+
+	want := "12:57PM"
+	if got != want {
+		t.Errorf("processTimeString() = %q, want %q", got, want)
+	}
+}
+
+func Test_extractTimeString(t *testing.T) {
+	opts := &options{in: "", out: "unix", loc: locationValue{Location: time.UTC}}
+
+	line := "2023-10-26T12:57:09Z app started, next check 2023-10-26T13:57:09Z"
+
+	got, err := extractTimeString(line, opts, false)
+	if err != nil {
+		t.Fatalf("extractTimeString failed: %v", err)
+	}
+	want := "1698325029 app started, next check 2023-10-26T13:57:09Z"
+	if got != want {
+		t.Errorf("extractTimeString() = %q, want %q", got, want)
+	}
+
+	got, err = extractTimeString(line, opts, true)
+	if err != nil {
+		t.Fatalf("extractTimeString failed: %v", err)
+	}
+	want = "1698325029 app started, next check 1698328629"
+	if got != want {
+		t.Errorf("extractTimeString(all) = %q, want %q", got, want)
+	}
+}
+
+func Test_extractTimeString_nineDigitUnix(t *testing.T) {
+	// 999999999 (2001-09-09T01:46:39Z) is a valid Unix epoch one digit
+	// shorter than guessRules' \d{9,19} minimum widened it to allow; the
+	// extraction pattern must recognize the same width or --extract misses
+	// it entirely.
+	opts := &options{in: "", out: "rfc3339", loc: locationValue{Location: time.UTC}}
+
+	got, err := extractTimeString("ts=999999999 foo", opts, false)
+	if err != nil {
+		t.Fatalf("extractTimeString failed: %v", err)
+	}
+	want := "ts=2001-09-09T01:46:39Z foo"
+	if got != want {
+		t.Errorf("extractTimeString() = %q, want %q", got, want)
+	}
+}
+
+func Test_replaceWithRegexp(t *testing.T) {
+	opts := &options{in: "rfc3339", out: "unix", loc: locationValue{Location: time.UTC}}
+	re := regexp.MustCompile(`\[(?P<ts>[^\]]+)\]`)
+
+	got := replaceWithRegexp("[2023-10-26T12:57:09Z] INFO some message", re, opts)
+	want := "[1698325029] INFO some message"
+	if got != want {
+		t.Errorf("replaceWithRegexp() = %q, want %q", got, want)
+	}
+}
+
+func Test_resolveAssumedLocation_springForwardGap(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+
+	// 2025-03-09 02:30:00 never occurred in America/New_York: clocks jumped
+	// from 01:59:59 EST straight to 03:00:00 EDT.
+	naive := time.Date(2025, 3, 9, 2, 30, 0, 0, time.UTC)
+
+	if _, err := resolveAssumedLocation(naive, ny, "error", "earlier"); err == nil {
+		t.Error("expected an error for a DST gap with dst-gap=error, got nil")
+	}
+
+	got, err := resolveAssumedLocation(naive, ny, "forward", "earlier")
+	if err != nil {
+		t.Fatalf("resolveAssumedLocation failed: %v", err)
+	}
+	want := time.Date(2025, 3, 9, 3, 30, 0, 0, ny)
+	if !got.Equal(want) {
+		t.Errorf("resolveAssumedLocation() = %v, want %v", got, want)
+	}
+}
+
+func Test_resolveAssumedLocation_fallBackFold(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+
+	// 2025-11-02 01:30:00 occurred twice in America/New_York: once as EDT
+	// before the clocks were set back, once as EST after.
+	naive := time.Date(2025, 11, 2, 1, 30, 0, 0, time.UTC)
+
+	earlier, err := resolveAssumedLocation(naive, ny, "error", "earlier")
+	if err != nil {
+		t.Fatalf("resolveAssumedLocation failed: %v", err)
+	}
+	if _, off := earlier.Zone(); off != -4*3600 {
+		t.Errorf("dst-fold=earlier offset = %d, want -14400 (EDT)", off)
+	}
+
+	later, err := resolveAssumedLocation(naive, ny, "error", "later")
+	if err != nil {
+		t.Fatalf("resolveAssumedLocation failed: %v", err)
+	}
+	if _, off := later.Zone(); off != -5*3600 {
+		t.Errorf("dst-fold=later offset = %d, want -18000 (EST)", off)
+	}
+
+	if !earlier.Before(later) {
+		t.Errorf("earlier fold %v should be before later fold %v", earlier, later)
+	}
+}
+
+func Test_processTimeString_assumeLocation(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	opts := &options{
+		in:        "datetime",
+		out:       "rfc3339",
+		loc:       locationValue{Location: time.UTC},
+		assumeLoc: optionalLocationValue{Location: ny, set: true},
+	}
+
+	got, err := processTimeString("2025-11-02 01:30:00", opts)
+	if err != nil {
+		t.Fatalf("processTimeString failed: %v", err)
+	}
+	want := "2025-11-02T05:30:00Z"
+	if got != want {
+		t.Errorf("processTimeString() = %q, want %q", got, want)
+	}
+}
+
 func Test_guessTime(t *testing.T) {
 	// This is implicitly tested by Test_stringToTime with auto-detection cases
 	// but we can add a specific one for a tricky case.
@@ -102,3 +259,65 @@ func Test_guessTime(t *testing.T) {
 		t.Errorf("expected %v, but got %v", expected, actual)
 	}
 }
+
+func Test_guessTime_slashDateAmbiguity(t *testing.T) {
+	candidates := guessCandidates("01/02/2006")
+	if len(candidates) < 2 {
+		t.Fatalf("guessCandidates() = %v, want at least 2 candidates for an ambiguous slash date", candidates)
+	}
+
+	chosen, runnerUp := candidates[0], candidates[1]
+	if chosen.layout != "strftime:%m/%d/%Y" {
+		t.Errorf("chosen layout = %q, want month-first by default", chosen.layout)
+	}
+	if runnerUp.layout != "strftime:%d/%m/%Y" {
+		t.Errorf("runner-up layout = %q, want day-first", runnerUp.layout)
+	}
+
+	if want := time.Date(2006, 1, 2, 0, 0, 0, 0, time.UTC); !chosen.t.Equal(want) {
+		t.Errorf("month-first reading = %v, want %v", chosen.t, want)
+	}
+	if want := time.Date(2006, 2, 1, 0, 0, 0, 0, time.UTC); !runnerUp.t.Equal(want) {
+		t.Errorf("day-first reading = %v, want %v", runnerUp.t, want)
+	}
+}
+
+// roundTripLayouts holds the known layouts that retain a full absolute
+// instant to the second (unlike dateonly, timeonly, kitchen and the Stamp
+// family, which drop the year and/or the date, and rfc822/rfc822z, which
+// only carry the time to the minute), so formatting then guessing can be
+// expected to land back within a second of the original time.
+var roundTripLayouts = []string{
+	"ansic", "unixdate", "rubydate",
+	"rfc850", "rfc1123", "rfc1123z",
+	"rfc3339", "rfc3339nano", "datetime",
+	"unix", "unix-milli", "unix-micro",
+}
+
+func FuzzGuessTime(f *testing.F) {
+	f.Add(int64(1698292629))
+	f.Add(int64(0))
+	f.Add(int64(-1))
+
+	// Keep generated times within a range where every round-trip layout is
+	// unambiguous: two-digit-year layouts (rfc822, rfc850, ...) can't
+	// reliably represent years outside roughly 1970-2069.
+	const window = 50 * 365 * 24 * 3600
+	base := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+
+	f.Fuzz(func(t *testing.T, sec int64) {
+		tm := time.Unix(base+sec%window, 0).UTC()
+
+		for _, format := range roundTripLayouts {
+			s := timeToString(tm, format)
+
+			got, err := guessTime(s)
+			if err != nil {
+				t.Fatalf("guessTime(%q) (formatted as %s from %v): %v", s, format, tm, err)
+			}
+			if diff := got.Unix() - tm.Unix(); diff < -1 || diff > 1 {
+				t.Errorf("guessTime(%q) (formatted as %s) = %v, want within 1s of %v", s, format, got, tm)
+			}
+		}
+	})
+}
@@ -0,0 +1,163 @@
+// Package strftime translates strftime(3)-style format strings into the Go
+// reference-time layout understood by time.Parse and time.Format, so users
+// coming from date(1), Python, Ruby, C or syslog tooling can describe a
+// timestamp layout without learning Go's "2006-01-02" reference clock.
+package strftime
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// conversions maps a single strftime conversion character to the Go layout
+// fragment it stands for. %Y/%y, %a/%A, %b/%B and %z/%Z are case sensitive,
+// so callers must not fold the format string to a single case before
+// translating it.
+var conversions = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'p': "PM",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'z': "-0700",
+	'Z': "MST",
+	'j': "002",
+	'N': "000000000",
+	'%': "%",
+}
+
+// patterns maps a single strftime conversion character to a regular
+// expression fragment that matches the text it produces, for use when
+// searching for a timestamp embedded in a larger string.
+var patterns = map[byte]string{
+	'Y': `\d{4}`,
+	'y': `\d{2}`,
+	'm': `\d{2}`,
+	'd': `\d{2}`,
+	'e': `[ \d]\d`,
+	'H': `\d{2}`,
+	'I': `\d{2}`,
+	'M': `\d{2}`,
+	'S': `\d{2}`,
+	'p': `[AP]M`,
+	'b': `[A-Za-z]{3}`,
+	'B': `[A-Za-z]+`,
+	'a': `[A-Za-z]{3}`,
+	'A': `[A-Za-z]+`,
+	'z': `[+-]\d{4}`,
+	'Z': `[A-Za-z]{2,5}`,
+	'j': `\d{3}`,
+	'N': `\d+`,
+	'%': `%`,
+}
+
+// ToPattern converts a strftime-style format string into a regular
+// expression that matches text shaped like that format, for locating a
+// timestamp embedded in a larger line rather than parsing a line that is
+// known to be nothing but a timestamp. As with ToLayout, "%s" is only
+// accepted as the entire format and reports epoch=true; the caller should
+// use its own pattern for epoch seconds instead of the returned one.
+func ToPattern(format string) (pattern string, epoch bool, err error) {
+	if format == "%s" {
+		return "", true, nil
+	}
+
+	var b strings.Builder
+	var lit strings.Builder
+	flushLit := func() {
+		if lit.Len() > 0 {
+			b.WriteString(regexp.QuoteMeta(lit.String()))
+			lit.Reset()
+		}
+	}
+
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			lit.WriteByte(c)
+			continue
+		}
+
+		if i+1 >= len(format) {
+			return "", false, fmt.Errorf("strftime: dangling %%%% at end of format %q", format)
+		}
+
+		if format[i+1] == ':' && i+2 < len(format) && format[i+2] == 'z' {
+			flushLit()
+			b.WriteString(`[+-]\d{2}:\d{2}`)
+			i += 2
+			continue
+		}
+
+		if format[i+1] == 's' {
+			return "", false, fmt.Errorf("strftime: %%s must be the entire format, not embedded in %q", format)
+		}
+
+		conv, ok := patterns[format[i+1]]
+		if !ok {
+			return "", false, fmt.Errorf("strftime: unsupported conversion %%%c in format %q", format[i+1], format)
+		}
+		flushLit()
+		b.WriteString(conv)
+		i++
+	}
+	flushLit()
+
+	return b.String(), false, nil
+}
+
+// ToLayout converts a strftime-style format string into the equivalent Go
+// reference layout. Literal text, including anything that isn't a %-escape,
+// passes through unchanged.
+//
+// %s (Unix seconds) can't be expressed as a Go reference layout fragment, so
+// it is only accepted as the entire format string; ToLayout reports epoch=true
+// in that case and the caller should fall back to its own epoch handling
+// (e.g. the "unix" layout) instead of using the returned layout.
+func ToLayout(format string) (layout string, epoch bool, err error) {
+	if format == "%s" {
+		return "", true, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+
+		if i+1 >= len(format) {
+			return "", false, fmt.Errorf("strftime: dangling %%%% at end of format %q", format)
+		}
+
+		if format[i+1] == ':' && i+2 < len(format) && format[i+2] == 'z' {
+			b.WriteString("-07:00")
+			i += 2
+			continue
+		}
+
+		if format[i+1] == 's' {
+			return "", false, fmt.Errorf("strftime: %%s must be the entire format, not embedded in %q", format)
+		}
+
+		conv, ok := conversions[format[i+1]]
+		if !ok {
+			return "", false, fmt.Errorf("strftime: unsupported conversion %%%c in format %q", format[i+1], format)
+		}
+		b.WriteString(conv)
+		i++
+	}
+
+	return b.String(), false, nil
+}
@@ -0,0 +1,97 @@
+package strftime
+
+import (
+	"regexp"
+	"testing"
+)
+
+func Test_ToLayout(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		wantLayout string
+		wantEpoch  bool
+		wantErr    bool
+	}{
+		{"ISO8601 with zone", "%Y-%m-%dT%H:%M:%S%z", "2006-01-02T15:04:05-0700", false, false},
+		{"ISO8601 with colon zone", "%Y-%m-%dT%H:%M:%S%:z", "2006-01-02T15:04:05-07:00", false, false},
+		{"Syslog stamp", "%b %d %H:%M:%S", "Jan 02 15:04:05", false, false},
+		{"Syslog stamp, space-padded day", "%b %e %H:%M:%S", "Jan _2 15:04:05", false, false},
+		{"Nanoseconds", "%Y-%m-%dT%H:%M:%S.%N", "2006-01-02T15:04:05.000000000", false, false},
+		{"Literal percent", "100%%", "100%", false, false},
+		{"Unix seconds", "%s", "", true, false},
+		{"Literal text passthrough", "Reported at %H:%M", "Reported at 15:04", false, false},
+		{"Unknown conversion", "%Q", "", false, true},
+		{"Dangling percent", "%Y-%", "", false, true},
+		{"Embedded unix seconds", "%s %Y", "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotLayout, gotEpoch, err := ToLayout(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToLayout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotLayout != tt.wantLayout {
+				t.Errorf("ToLayout() layout = %q, want %q", gotLayout, tt.wantLayout)
+			}
+			if gotEpoch != tt.wantEpoch {
+				t.Errorf("ToLayout() epoch = %v, want %v", gotEpoch, tt.wantEpoch)
+			}
+		})
+	}
+}
+
+func Test_ToPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		match   string
+		noMatch string
+		wantErr bool
+	}{
+		{"ISO8601 with zone", "%Y-%m-%dT%H:%M:%S%z", "2023-10-26T12:57:09+0900", "not a timestamp", false},
+		{"Syslog stamp", "%b %e %H:%M:%S", "Jul  6 09:03:11", "Jul 6x09:03:11", false},
+		{"Literal text passthrough", "[%H:%M:%S]", "[12:57:09]", "12:57:09", false},
+		{"Unknown conversion", "%Q", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pattern, epoch, err := ToPattern(tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ToPattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if epoch {
+				t.Fatalf("ToPattern() epoch = true, want false")
+			}
+
+			re := regexp.MustCompile(pattern)
+			if !re.MatchString(tt.match) {
+				t.Errorf("pattern %q did not match %q", pattern, tt.match)
+			}
+			if re.MatchString(tt.noMatch) {
+				t.Errorf("pattern %q unexpectedly matched %q", pattern, tt.noMatch)
+			}
+		})
+	}
+}
+
+func Test_ToPattern_epoch(t *testing.T) {
+	pattern, epoch, err := ToPattern("%s")
+	if err != nil {
+		t.Fatalf("ToPattern() error = %v", err)
+	}
+	if !epoch {
+		t.Fatalf("ToPattern() epoch = false, want true")
+	}
+	if pattern != "" {
+		t.Errorf("ToPattern() pattern = %q, want empty", pattern)
+	}
+}